@@ -15,6 +15,30 @@ type ManagerConfig struct {
 	LeveledLogger      logging.LeveledLogger
 	AllocatePacketConn func(network string, requestedPort int) (net.PacketConn, net.Addr, error)
 	AllocateConn       func(network string, requestedPort int) (net.Listener, net.Addr, error)
+
+	// NATTraversal maps each allocation's locally bound relay port to an
+	// externally reachable address:port, for relays hosted behind a NAT.
+	// Defaults to DisabledNATTraversal if left nil, using the locally bound
+	// address as-is. Set to AutoDiscoverNAT to probe UPnP and NAT-PMP on
+	// startup instead, for the residential/consumer-NAT case; this blocks
+	// NewManager for up to natDiscoveryTimeout. Set to NewStaticExternalIP
+	// to skip discovery entirely, e.g. behind a cloud load balancer with a
+	// known public IP.
+	NATTraversal NATTraversal
+
+	// Store persists allocation and reservation state so both survive a
+	// process restart. Defaults to NewMemoryStore, matching the manager's
+	// historical in-memory-only behaviour.
+	Store Store
+
+	// Quota bounds per-source-IP and total allocation/connection counts. A
+	// zero value leaves Manager unbounded, matching its historical behaviour.
+	Quota Quota
+
+	// Clock drives reservation expiry, NAT mapping refresh, and the
+	// 30-second peer data connection timeout. Defaults to NewRealClock; a
+	// manual clock lets tests advance time without sleeping.
+	Clock Clock
 }
 
 type reservation struct {
@@ -34,6 +58,34 @@ type Manager struct {
 
 	allocatePacketConn func(network string, requestedPort int) (net.PacketConn, net.Addr, error)
 	allocateConn       func(network string, requestedPort int) (net.Listener, net.Addr, error)
+
+	nat      NATTraversal
+	natLock  sync.Mutex
+	mappings map[string]*natMapping
+
+	store *asyncStore
+
+	// pendingRelays holds relay sockets pre-bound from a persisted
+	// AllocationRecord on startup, keyed by fingerprint, so a reconnecting
+	// client that reproduces the same FiveTuple gets its old relay address
+	// back instead of a freshly allocated one.
+	pendingRelays map[string]*pendingRelay
+
+	quota *quotaTracker
+
+	clock Clock
+}
+
+// pendingRelay is a relay socket bound ahead of time for a restored
+// allocation, handed off to the real Allocation once the client reconnects.
+type pendingRelay struct {
+	protocol      string
+	conn          net.PacketConn
+	listener      net.Listener
+	relayAddr     net.Addr
+	requestedPort int
+	deadline      time.Time
+	expiryTimer   Timer
 }
 
 // NewManager creates a new instance of Manager.
@@ -48,14 +100,113 @@ func NewManager(config ManagerConfig) (*Manager, error) {
 		return nil, fmt.Errorf("LeveledLogger must be set")
 	}
 
-	return &Manager{
+	nat := config.NATTraversal
+	switch {
+	case nat == nil:
+		nat = DisabledNATTraversal
+	case nat == AutoDiscoverNAT:
+		nat = discoverNATTraversal(natDiscoveryTimeout, config.LeveledLogger)
+	}
+
+	backingStore := config.Store
+	if backingStore == nil {
+		backingStore = NewMemoryStore()
+	}
+
+	clock := config.Clock
+	if clock == nil {
+		clock = NewRealClock()
+	}
+
+	var quota *quotaTracker
+	if config.Quota != (Quota{}) {
+		quota = newQuotaTracker(config.Quota, clock)
+	}
+
+	m := &Manager{
 		log:                config.LeveledLogger,
 		allocations:        make(map[string]*Allocation, 64),
+		waitingconns:       make(map[uint32]*Allocation),
+		runningconns:       make(map[uint32]*Allocation),
 		allocatePacketConn: config.AllocatePacketConn,
 		allocateConn:       config.AllocateConn,
-	}, nil
+		nat:                nat,
+		mappings:           make(map[string]*natMapping),
+		store:              newAsyncStore(backingStore, config.LeveledLogger),
+		pendingRelays:      make(map[string]*pendingRelay),
+		quota:              quota,
+		clock:              clock,
+	}
+
+	m.restoreFromStore()
+
+	return m, nil
+}
+
+// restoreFromStore loads persisted allocations and reservations, re-binding
+// each non-expired allocation's relay port so the relay address stays
+// stable for a reconnecting client. Entries whose deadline has already
+// passed are dropped.
+func (m *Manager) restoreFromStore() {
+	snapshot, err := m.store.loadAll()
+	if err != nil {
+		m.log.Errorf("failed to load persisted allocation state: %v", err)
+		return
+	}
+
+	now := m.clock.Now()
+	for _, rec := range snapshot.Allocations {
+		fingerprint := rec.Fingerprint
+		log := withContext(m.log, "fingerprint", fingerprint)
+
+		if !rec.Deadline.After(now) {
+			m.store.deleteAllocation(fingerprint)
+			continue
+		}
+
+		relay, err := m.bindPendingRelay(rec)
+		if err != nil {
+			log.Errorf("failed to re-bind persisted relay: %v", err)
+			m.store.deleteAllocation(fingerprint)
+			continue
+		}
+
+		relay.expiryTimer = m.clock.AfterFunc(rec.Deadline.Sub(now), func() {
+			m.expirePendingRelay(fingerprint)
+		})
+
+		m.pendingRelays[fingerprint] = relay
+		withContext(log, "relay", relay.relayAddr).Debugf("restored pending relay")
+	}
+
+	for _, rec := range snapshot.Reservations {
+		m.reservations = append(m.reservations, &reservation{token: rec.Token, port: rec.Port})
+	}
+}
+
+func (m *Manager) bindPendingRelay(rec AllocationRecord) (*pendingRelay, error) {
+	switch rec.Protocol {
+	case "udp":
+		conn, relayAddr, err := m.allocatePacketConn("udp4", rec.RequestedPort)
+		if err != nil {
+			return nil, err
+		}
+		return &pendingRelay{protocol: rec.Protocol, conn: conn, relayAddr: relayAddr, requestedPort: rec.RequestedPort, deadline: rec.Deadline}, nil
+	case "tcp":
+		listener, relayAddr, err := m.allocateConn("tcp4", rec.RequestedPort)
+		if err != nil {
+			return nil, err
+		}
+		return &pendingRelay{protocol: rec.Protocol, listener: listener, relayAddr: relayAddr, requestedPort: rec.RequestedPort, deadline: rec.Deadline}, nil
+	default:
+		return nil, fmt.Errorf("unknown persisted allocation protocol %q", rec.Protocol)
+	}
 }
 
+// natDiscoveryTimeout bounds how long NewManager waits for a UPnP or
+// NAT-PMP gateway to respond before assuming there is no NAT to traverse.
+const natDiscoveryTimeout = 3 * time.Second
+
 // GetAllocation fetches the allocation matching the passed FiveTuple
 func (m *Manager) GetAllocation(fiveTuple *FiveTuple) *Allocation {
 	m.lock.RLock()
@@ -66,13 +217,43 @@ func (m *Manager) GetAllocation(fiveTuple *FiveTuple) *Allocation {
 // Close closes the manager and closes all allocations it manages
 func (m *Manager) Close() error {
 	m.lock.Lock()
-	defer m.lock.Unlock()
 
-	for _, a := range m.allocations {
+	for fingerprint, a := range m.allocations {
+		m.releaseExternal(fingerprint)
 		if err := a.Close(); err != nil {
+			m.lock.Unlock()
 			return err
 		}
 	}
+	for fingerprint, relay := range m.pendingRelays {
+		if relay.expiryTimer != nil {
+			relay.expiryTimer.Stop()
+		}
+		if err := closePendingRelay(relay); err != nil {
+			withContext(m.log, "fingerprint", fingerprint).Errorf("failed to close pending relay: %v", err)
+		}
+	}
+	m.pendingRelays = make(map[string]*pendingRelay)
+
+	m.lock.Unlock()
+
+	return m.store.close()
+}
+
+// releaseQuota returns ip's allocation slot, if a Quota is configured.
+func (m *Manager) releaseQuota(ip string) {
+	if m.quota != nil {
+		m.quota.release(ip)
+	}
+}
+
+func closePendingRelay(relay *pendingRelay) error {
+	if relay.conn != nil {
+		return relay.conn.Close()
+	}
+	if relay.listener != nil {
+		return relay.listener.Close()
+	}
 	return nil
 }
 
@@ -94,54 +275,140 @@ func (m *Manager) CreateAllocation(fiveTuple *FiveTuple, turnSocket net.PacketCo
 	if a := m.GetAllocation(fiveTuple); a != nil {
 		return nil, fmt.Errorf("allocation attempt created with duplicate FiveTuple %v", fiveTuple)
 	}
-	a := NewAllocation(turnSocket, fiveTuple, m.log)
+
+	srcIP := ipFromAddr(fiveTuple.SrcAddr)
+	if m.quota != nil {
+		if err := m.quota.reserve(srcIP); err != nil {
+			return nil, err
+		}
+	}
+
+	fingerprint := fiveTuple.Fingerprint()
+	log := withContext(m.log, "fivetuple", fingerprint, "src", fiveTuple.SrcAddr, "dst", fiveTuple.DstAddr, "proto", fiveTuple.Protocol)
+
+	a := NewAllocation(turnSocket, fiveTuple, log)
+	pending := m.takePendingRelay(fingerprint)
 
 	switch fiveTuple.Protocol {
 	case UDP:
-		conn, relayAddr, err := m.allocatePacketConn("udp4", requestedPort)
-		if err != nil {
-			return nil, err
+		var conn net.PacketConn
+		var relayAddr net.Addr
+		if pending != nil && pending.conn != nil {
+			conn, relayAddr = pending.conn, pending.relayAddr
+		} else {
+			var err error
+			conn, relayAddr, err = m.allocatePacketConn("udp4", requestedPort)
+			if err != nil {
+				m.releaseQuota(srcIP)
+				return nil, err
+			}
 		}
 
 		a.RelaySocket = conn
 		a.RelayAddr = relayAddr
+		localPort := relayPort(a.RelayAddr)
 
-		m.log.Debugf("listening on relay addr: %s", a.RelayAddr.String())
+		relayLog := withContext(log, "relay", a.RelayAddr)
+		relayLog.Debugf("allocation created")
+		m.mapExternal("udp", fingerprint, a, lifetime)
 
-		a.lifetimeTimer = time.AfterFunc(lifetime, func() {
+		a.lifetimeTimer = m.clock.AfterFunc(lifetime, func() {
 			m.DeleteAllocation(a.fiveTuple)
 		})
 
 		m.lock.Lock()
-		m.allocations[fiveTuple.Fingerprint()] = a
+		m.allocations[fingerprint] = a
 		m.lock.Unlock()
 
+		m.store.saveAllocation(AllocationRecord{
+			Fingerprint:   fingerprint,
+			Protocol:      "udp",
+			RequestedPort: localPort,
+			Deadline:      m.clock.Now().Add(lifetime),
+		})
+
 		go a.packetHandler(m)
 	case TCP:
-		listener, relayAddr, err := m.allocateConn("tcp4", requestedPort)
-		if err != nil {
-			return nil, err
+		var listener net.Listener
+		var relayAddr net.Addr
+		if pending != nil && pending.listener != nil {
+			listener, relayAddr = pending.listener, pending.relayAddr
+		} else {
+			var err error
+			listener, relayAddr, err = m.allocateConn("tcp4", requestedPort)
+			if err != nil {
+				m.releaseQuota(srcIP)
+				return nil, err
+			}
 		}
 
 		a.RelayListener = listener
 		a.RelayAddr = relayAddr
+		localPort := relayPort(a.RelayAddr)
 
-		m.log.Debugf("listening on relay addr: %s", a.RelayAddr.String())
+		relayLog := withContext(log, "relay", a.RelayAddr)
+		relayLog.Debugf("allocation created")
+		m.mapExternal("tcp", fingerprint, a, lifetime)
 
-		a.lifetimeTimer = time.AfterFunc(lifetime, func() {
+		a.lifetimeTimer = m.clock.AfterFunc(lifetime, func() {
 			m.DeleteAllocation(a.fiveTuple)
 		})
 
 		m.lock.Lock()
-		m.allocations[fiveTuple.Fingerprint()] = a
+		m.allocations[fingerprint] = a
 		m.lock.Unlock()
 
+		m.store.saveAllocation(AllocationRecord{
+			Fingerprint:   fingerprint,
+			Protocol:      "tcp",
+			RequestedPort: localPort,
+			Deadline:      m.clock.Now().Add(lifetime),
+		})
+
 		go a.listenHandler(m)
 	}
 
 	return a, nil
 }
 
+// takePendingRelay returns and removes the relay pre-bound for fingerprint
+// during restoreFromStore, if any, and stops its expiry timer so that timer
+// does not close a socket the real Allocation now owns.
+func (m *Manager) takePendingRelay(fingerprint string) *pendingRelay {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	relay := m.pendingRelays[fingerprint]
+	delete(m.pendingRelays, fingerprint)
+	if relay != nil && relay.expiryTimer != nil {
+		relay.expiryTimer.Stop()
+	}
+	return relay
+}
+
+// expirePendingRelay closes and forgets a restored relay whose original
+// client never reconnected before rec.Deadline, so its socket/port doesn't
+// stay bound forever.
+func (m *Manager) expirePendingRelay(fingerprint string) {
+	m.lock.Lock()
+	relay, ok := m.pendingRelays[fingerprint]
+	if ok {
+		delete(m.pendingRelays, fingerprint)
+	}
+	m.lock.Unlock()
+
+	if !ok {
+		return
+	}
+
+	log := withContext(m.log, "fingerprint", fingerprint)
+	if err := closePendingRelay(relay); err != nil {
+		log.Errorf("failed to close expired pending relay: %v", err)
+	}
+	m.store.deleteAllocation(fingerprint)
+	log.Debugf("expired pending relay")
+}
+
 // DeleteAllocation removes an allocation
 func (m *Manager) DeleteAllocation(fiveTuple *FiveTuple) {
 	fingerprint := fiveTuple.Fingerprint()
@@ -155,19 +422,131 @@ func (m *Manager) DeleteAllocation(fiveTuple *FiveTuple) {
 		return
 	}
 
+	m.releaseExternal(fingerprint)
+	m.store.deleteAllocation(fingerprint)
+	m.releaseQuota(ipFromAddr(fiveTuple.SrcAddr))
+
+	log := withContext(m.log, "fivetuple", fingerprint, "src", fiveTuple.SrcAddr, "dst", fiveTuple.DstAddr, "proto", fiveTuple.Protocol)
 	if err := allocation.Close(); err != nil {
-		m.log.Errorf("Failed to close allocation: %v", err)
+		log.Errorf("failed to close allocation: %v", err)
+	} else {
+		log.Debugf("allocation deleted")
+	}
+}
+
+// mapExternal asks m.nat for an external mapping of a's locally bound relay
+// port, replaces a.RelayAddr with the discovered external address, and
+// arranges for the mapping to be renewed for as long as the allocation
+// lives. It is a no-op if no NATTraversal is configured.
+func (m *Manager) mapExternal(protocol, fingerprint string, a *Allocation, lifetime time.Duration) {
+	if m.nat == nil {
+		return
+	}
+	if _, disabled := m.nat.(disabledNATTraversal); disabled {
+		return
+	}
+
+	localPort := relayPort(a.RelayAddr)
+	if localPort == 0 {
+		return
+	}
+
+	log := withContext(m.log, "fingerprint", fingerprint, "protocol", protocol, "port", localPort)
+
+	externalAddr, mappingLifetime, err := m.nat.AddPortMapping(protocol, localPort, "pion-turn relay")
+	if err != nil {
+		log.Errorf("failed to create NAT mapping: %v", err)
+		return
+	}
+
+	a.RelayAddr = externalAddr
+	withContext(log, "relay", externalAddr).Debugf("mapped external relay addr")
+
+	refresh := mappingLifetime / 2
+	if refresh <= 0 || refresh > lifetime {
+		refresh = lifetime / 2
+	}
+
+	mapping := &natMapping{protocol: protocol, internalPort: localPort}
+	mapping.refreshTimer = m.clock.AfterFunc(refresh, func() {
+		m.refreshExternal(fingerprint, protocol, localPort, lifetime)
+	})
+
+	m.natLock.Lock()
+	m.mappings[fingerprint] = mapping
+	m.natLock.Unlock()
+}
+
+func (m *Manager) refreshExternal(fingerprint, protocol string, localPort int, lifetime time.Duration) {
+	log := withContext(m.log, "fingerprint", fingerprint, "protocol", protocol, "port", localPort)
+
+	externalAddr, mappingLifetime, err := m.nat.AddPortMapping(protocol, localPort, "pion-turn relay")
+	if err != nil {
+		log.Errorf("failed to refresh NAT mapping: %v", err)
+		return
+	}
+	withContext(log, "relay", externalAddr).Debugf("refreshed external relay addr")
+
+	refresh := mappingLifetime / 2
+	if refresh <= 0 || refresh > lifetime {
+		refresh = lifetime / 2
+	}
+
+	m.natLock.Lock()
+	defer m.natLock.Unlock()
+	mapping, ok := m.mappings[fingerprint]
+	if !ok {
+		return
+	}
+	mapping.refreshTimer = m.clock.AfterFunc(refresh, func() {
+		m.refreshExternal(fingerprint, protocol, localPort, lifetime)
+	})
+}
+
+// releaseExternal stops renewing and tears down the NAT mapping for fingerprint, if any.
+func (m *Manager) releaseExternal(fingerprint string) {
+	if m.nat == nil {
+		return
+	}
+
+	m.natLock.Lock()
+	mapping, ok := m.mappings[fingerprint]
+	if ok {
+		delete(m.mappings, fingerprint)
+	}
+	m.natLock.Unlock()
+
+	if !ok {
+		return
+	}
+
+	mapping.refreshTimer.Stop()
+	if err := m.nat.RemovePortMapping(mapping.protocol, mapping.internalPort); err != nil {
+		withContext(m.log, "fingerprint", fingerprint, "protocol", mapping.protocol, "port", mapping.internalPort).Errorf("failed to remove NAT mapping: %v", err)
+	}
+}
+
+// relayPort extracts the numeric port from a UDP or TCP relay address.
+func relayPort(addr net.Addr) int {
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		return a.Port
+	case *net.TCPAddr:
+		return a.Port
+	default:
+		return 0
 	}
 }
 
 // CreateReservation stores the reservation for the token+port
 func (m *Manager) CreateReservation(reservationToken string, port int) {
-	time.AfterFunc(30*time.Second, func() {
+	m.clock.AfterFunc(30*time.Second, func() {
 		m.lock.Lock()
 		defer m.lock.Unlock()
 		for i := len(m.reservations) - 1; i >= 0; i-- {
 			if m.reservations[i].token == reservationToken {
 				m.reservations = append(m.reservations[:i], m.reservations[i+1:]...)
+				m.store.deleteReservation(reservationToken)
 				return
 			}
 		}
@@ -179,6 +558,8 @@ func (m *Manager) CreateReservation(reservationToken string, port int) {
 		port:  port,
 	})
 	m.lock.Unlock()
+
+	m.store.saveReservation(ReservationRecord{Token: reservationToken, Port: port})
 }
 
 // GetReservation returns the port for a given reservation if it exists
@@ -222,16 +603,23 @@ func (m *Manager) BindConnection(cid uint32) net.Conn {
 		return nil
 	}
 	m.runningconns[cid] = a
+
+	withContext(m.log, "fivetuple", a.fiveTuple.Fingerprint(), "cid", cid).Debugf("connection bound")
+
 	return a.GetConnectionByID(cid)
 }
 
 func (m *Manager) Connect(a *Allocation, dst string) (uint32, error) {
-	cid := m.newCID(a)
-
-	err := a.connect(cid, dst)
+	cid, err := m.reserveCID(a)
 	if err != nil {
 		return 0, err
 	}
+	log := withContext(m.log, "fivetuple", a.fiveTuple.Fingerprint(), "cid", cid, "dst", dst)
+
+	if err := a.connect(cid, dst); err != nil {
+		return 0, err
+	}
+	log.Debugf("peer connection requested")
 
 	// If no ConnectionBind request associated with this peer data
 	// connection is received after 30 seconds, the peer data connection
@@ -242,7 +630,7 @@ func (m *Manager) Connect(a *Allocation, dst string) (uint32, error) {
 }
 
 func (m *Manager) removeAfter30(cid uint32, dst string) {
-	<-time.After(30 * time.Second)
+	<-m.clock.After(30 * time.Second)
 	m.lock.Lock()
 	defer m.lock.Unlock()
 	a, ok := m.waitingconns[cid]
@@ -253,8 +641,39 @@ func (m *Manager) removeAfter30(cid uint32, dst string) {
 	a.removeConnection(cid, dst)
 }
 
-func (m *Manager) newCID(a *Allocation) uint32 {
+// countConnectionsLocked returns how many waiting or running peer data
+// connections currently belong to a, for enforcing
+// Quota.MaxConnectionsPerAllocation. Callers must hold m.lock.
+func (m *Manager) countConnectionsLocked(a *Allocation) int {
+	count := 0
+	for _, owner := range m.waitingconns {
+		if owner == a {
+			count++
+		}
+	}
+	for _, owner := range m.runningconns {
+		if owner == a {
+			count++
+		}
+	}
+	return count
+}
+
+// reserveCID checks a against Quota.MaxConnectionsPerAllocation and, if it
+// passes, allocates a fresh CID and registers it in m.waitingconns, all
+// under a single critical section. Checking and registering separately
+// would let concurrent Connect calls on the same Allocation all observe the
+// count under the limit before any of them registers, exceeding the quota.
+func (m *Manager) reserveCID(a *Allocation) (uint32, error) {
 	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.quota != nil && m.quota.cfg.MaxConnectionsPerAllocation > 0 {
+		if m.countConnectionsLocked(a) >= m.quota.cfg.MaxConnectionsPerAllocation {
+			return 0, ErrQuotaConnections
+		}
+	}
+
 	var cid uint32
 	for {
 		cid = rand.Uint32()
@@ -269,7 +688,6 @@ func (m *Manager) newCID(a *Allocation) uint32 {
 		}
 	}
 	m.waitingconns[cid] = a
-	m.lock.Unlock()
 
-	return cid
+	return cid, nil
 }
@@ -0,0 +1,33 @@
+package allocation
+
+import "time"
+
+// Timer is the subset of *time.Timer that Manager needs, so Clock
+// implementations can hand back something other than the real thing.
+type Timer interface {
+	Stop() bool
+}
+
+// Clock abstracts time so Manager's timer-driven behaviour (allocation
+// lifetimes, the 30-second removeAfter30 CID cleanup, NAT mapping refresh)
+// can be driven deterministically in tests. Production wraps time.AfterFunc
+// and time.After directly; tests substitute a manual clock that advances on
+// demand instead of sleeping.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// realClock is the production Clock, backed by the time package.
+type realClock struct{}
+
+// NewRealClock returns the production Clock, backed by the time package.
+func NewRealClock() Clock { return realClock{} }
+
+func (realClock) Now() time.Time                        { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+func (realClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
@@ -0,0 +1,86 @@
+package allocation
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pion/logging"
+)
+
+// ContextLogger is an optional extension to logging.LeveledLogger for
+// implementations that can produce a child logger carrying persistent
+// key/value context natively (e.g. a structured logging backend), instead
+// of going through the string-formatting ctxLogger below.
+type ContextLogger interface {
+	logging.LeveledLogger
+
+	// WithContext returns a logger that behaves like the receiver but
+	// includes pairs ("key", value, "key", value, ...) with every line it
+	// logs afterwards.
+	WithContext(pairs ...interface{}) logging.LeveledLogger
+}
+
+// withContext returns a child of log carrying pairs as persistent context.
+// If log already implements ContextLogger, its native WithContext is used;
+// otherwise the pairs are formatted deterministically and appended to every
+// message via ctxLogger.
+func withContext(log logging.LeveledLogger, pairs ...interface{}) logging.LeveledLogger {
+	if len(pairs) == 0 {
+		return log
+	}
+	if cl, ok := log.(ContextLogger); ok {
+		return cl.WithContext(pairs...)
+	}
+	return &ctxLogger{LeveledLogger: log, suffix: formatContext(pairs...)}
+}
+
+// formatContext renders pairs as space-separated "key=value" text, in the
+// order given, so the same call site always produces the same layout.
+func formatContext(pairs ...interface{}) string {
+	var b strings.Builder
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		fmt.Fprintf(&b, "%v=%v", pairs[i], pairs[i+1])
+	}
+	return b.String()
+}
+
+// ctxLogger decorates a logging.LeveledLogger with a fixed suffix of
+// key/value context, since pion/logging.LeveledLogger has no With-keys
+// method of its own.
+type ctxLogger struct {
+	logging.LeveledLogger
+	suffix string
+}
+
+func (c *ctxLogger) WithContext(pairs ...interface{}) logging.LeveledLogger {
+	return &ctxLogger{LeveledLogger: c.LeveledLogger, suffix: c.suffix + " " + formatContext(pairs...)}
+}
+
+func (c *ctxLogger) append(msg string) string {
+	return msg + " " + c.suffix
+}
+
+func (c *ctxLogger) Trace(msg string) { c.LeveledLogger.Trace(c.append(msg)) }
+func (c *ctxLogger) Debug(msg string) { c.LeveledLogger.Debug(c.append(msg)) }
+func (c *ctxLogger) Info(msg string)  { c.LeveledLogger.Info(c.append(msg)) }
+func (c *ctxLogger) Warn(msg string)  { c.LeveledLogger.Warn(c.append(msg)) }
+func (c *ctxLogger) Error(msg string) { c.LeveledLogger.Error(c.append(msg)) }
+
+func (c *ctxLogger) Tracef(format string, args ...interface{}) {
+	c.LeveledLogger.Tracef(c.append(format), args...)
+}
+func (c *ctxLogger) Debugf(format string, args ...interface{}) {
+	c.LeveledLogger.Debugf(c.append(format), args...)
+}
+func (c *ctxLogger) Infof(format string, args ...interface{}) {
+	c.LeveledLogger.Infof(c.append(format), args...)
+}
+func (c *ctxLogger) Warnf(format string, args ...interface{}) {
+	c.LeveledLogger.Warnf(c.append(format), args...)
+}
+func (c *ctxLogger) Errorf(format string, args ...interface{}) {
+	c.LeveledLogger.Errorf(c.append(format), args...)
+}
@@ -0,0 +1,72 @@
+package allocation_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pion/logging"
+
+	"github.com/schivei/turn/internal/allocation"
+	"github.com/schivei/turn/internal/allocation/simnet"
+)
+
+// TestManualClockDrivesExpiry exercises the two timers simnet exists to
+// make deterministic: an allocation's lifetimeTimer, and the 30-second
+// removeAfter30 cleanup for a peer data connection awaiting ConnectionBind.
+func TestManualClockDrivesExpiry(t *testing.T) {
+	log := logging.NewDefaultLoggerFactory().NewLogger("test")
+
+	cluster, err := simnet.NewCluster(1, log, time.Unix(0, 0))
+	if err != nil {
+		t.Fatalf("failed to create cluster: %v", err)
+	}
+	defer func() {
+		if err := cluster.Close(); err != nil {
+			t.Fatalf("failed to close cluster: %v", err)
+		}
+	}()
+
+	m := cluster.Managers[0]
+
+	turnSocket, _, err := cluster.Switch.AllocatePacketConn("udp4", 0)
+	if err != nil {
+		t.Fatalf("failed to allocate turn socket: %v", err)
+	}
+	defer turnSocket.Close()
+
+	fiveTuple := &allocation.FiveTuple{
+		Protocol: allocation.UDP,
+		SrcAddr:  &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 5000},
+		DstAddr:  &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 3478},
+	}
+
+	a, err := m.CreateAllocation(fiveTuple, turnSocket, 0, time.Second)
+	if err != nil {
+		t.Fatalf("failed to create allocation: %v", err)
+	}
+
+	if m.GetAllocation(fiveTuple) == nil {
+		t.Fatalf("allocation not tracked right after creation")
+	}
+
+	cid, err := m.Connect(a, "10.0.0.3:9000")
+	if err != nil {
+		t.Fatalf("failed to request peer connection: %v", err)
+	}
+
+	cluster.Clock.Advance(31 * time.Second)
+
+	// removeAfter30 runs the actual cleanup on its own goroutine once the
+	// manual clock delivers its channel, so give it a brief real-time
+	// window to catch up before asserting. BindConnection itself mutates
+	// state, so it can only be checked once.
+	time.Sleep(100 * time.Millisecond)
+	if conn := m.BindConnection(cid); conn != nil {
+		t.Fatalf("expected peer connection %d to be removed 30s after Connect with no bind", cid)
+	}
+
+	if m.GetAllocation(fiveTuple) != nil {
+		t.Fatalf("expected allocation to have expired after its 1s lifetime")
+	}
+}
@@ -0,0 +1,142 @@
+package allocation
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+// NATTraversal discovers and maintains an external address:port mapping for
+// a locally bound relay socket, so that RelayAddr remains reachable from
+// peers even when the server sits behind a NAT (e.g. a TURN server hosted
+// on a residential connection).
+type NATTraversal interface {
+	// AddPortMapping requests that protocol ("udp" or "tcp") traffic destined
+	// for internalPort be forwarded to this host, returning the externally
+	// reachable address and the duration the mapping remains valid for.
+	AddPortMapping(protocol string, internalPort int, description string) (externalAddr net.Addr, lifetime time.Duration, err error)
+
+	// RemovePortMapping releases a mapping previously created by AddPortMapping.
+	RemovePortMapping(protocol string, internalPort int) error
+}
+
+// natMapping tracks a live port mapping so it can be refreshed and released.
+type natMapping struct {
+	protocol     string
+	internalPort int
+	refreshTimer Timer
+}
+
+// discoverNATTraversal probes UPnP IGDv1/v2 and NAT-PMP in parallel and
+// returns the first gateway that answers within timeout. If neither
+// responds, it returns nil, meaning "no NAT" (the caller keeps the locally
+// bound address as-is).
+func discoverNATTraversal(timeout time.Duration, log logLeveler) NATTraversal {
+	type result struct {
+		nat NATTraversal
+		err error
+	}
+
+	resCh := make(chan result, 2)
+	go func() {
+		nat, err := discoverUPnP(timeout)
+		resCh <- result{nat, err}
+	}()
+	go func() {
+		nat, err := discoverNATPMP(timeout)
+		resCh <- result{nat, err}
+	}()
+
+	deadline := time.After(timeout)
+	for i := 0; i < 2; i++ {
+		select {
+		case r := <-resCh:
+			if r.err != nil {
+				log.Debugf("NAT traversal probe failed: %v", r.err)
+				continue
+			}
+			return r.nat
+		case <-deadline:
+			i = 2
+		}
+	}
+
+	log.Debugf("no UPnP or NAT-PMP gateway responded within %s, assuming no NAT", timeout)
+	return nil
+}
+
+// staticExternalIP is a NATTraversal that never probes the network and
+// always reports a statically configured external address, for the common
+// cloud case where the external IP is known up front (e.g. via EIP/NLB).
+type staticExternalIP struct {
+	ip net.IP
+}
+
+// NewStaticExternalIP returns a NATTraversal that reports ip as the external
+// address for every mapping, without performing any discovery or renewal.
+func NewStaticExternalIP(ip net.IP) NATTraversal {
+	return &staticExternalIP{ip: ip}
+}
+
+func (s *staticExternalIP) AddPortMapping(protocol string, internalPort int, description string) (net.Addr, time.Duration, error) {
+	switch protocol {
+	case "udp":
+		return &net.UDPAddr{IP: s.ip, Port: internalPort}, 0, nil
+	case "tcp":
+		return &net.TCPAddr{IP: s.ip, Port: internalPort}, 0, nil
+	default:
+		return nil, 0, fmt.Errorf("unsupported protocol for static external IP: %s", protocol)
+	}
+}
+
+func (s *staticExternalIP) RemovePortMapping(string, int) error {
+	return nil
+}
+
+// disabledNATTraversal opts a Manager out of NAT traversal altogether: no
+// UPnP/NAT-PMP probing and no mapping attempts. This is ManagerConfig's
+// default, since most TURN deployments (datacenter, cloud, container) have
+// no residential-style IGD to find and would otherwise pay a startup
+// discovery timeout and send unsolicited multicast traffic for nothing.
+type disabledNATTraversal struct{}
+
+// DisabledNATTraversal is a NATTraversal that does nothing. This is
+// ManagerConfig's default when NATTraversal is left nil; setting it
+// explicitly only documents the choice at the call site.
+var DisabledNATTraversal NATTraversal = disabledNATTraversal{}
+
+func (disabledNATTraversal) AddPortMapping(string, int, string) (net.Addr, time.Duration, error) {
+	return nil, 0, nil
+}
+
+func (disabledNATTraversal) RemovePortMapping(string, int) error {
+	return nil
+}
+
+// autoDiscoverNAT is a sentinel NATTraversal: NewManager recognizes it and
+// substitutes the result of probing UPnP/NAT-PMP in its place, rather than
+// being a usable NATTraversal itself.
+type autoDiscoverNAT struct{}
+
+// AutoDiscoverNAT opts in to probing UPnP and NAT-PMP on startup, blocking
+// NewManager for up to natDiscoveryTimeout while it waits for a gateway to
+// respond. Set ManagerConfig.NATTraversal to this for the residential/
+// consumer-NAT case the probing is meant for; leave it nil (the default)
+// for datacenter, cloud, or containerized deployments, where no IGD will
+// ever answer and the wait only adds latency and multicast traffic.
+var AutoDiscoverNAT NATTraversal = autoDiscoverNAT{}
+
+func (autoDiscoverNAT) AddPortMapping(string, int, string) (net.Addr, time.Duration, error) {
+	return nil, 0, fmt.Errorf("allocation: AutoDiscoverNAT must be resolved by NewManager, not used directly")
+}
+
+func (autoDiscoverNAT) RemovePortMapping(string, int) error {
+	return fmt.Errorf("allocation: AutoDiscoverNAT must be resolved by NewManager, not used directly")
+}
+
+// logLeveler is the subset of logging.LeveledLogger the NAT traversal code
+// needs, kept narrow so it can be satisfied by ctxLogger too.
+type logLeveler interface {
+	Debugf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
@@ -0,0 +1,160 @@
+package allocation
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"time"
+)
+
+// natPMPClient is a minimal client for the NAT-PMP protocol (RFC 6886),
+// enough to discover the gateway's external address and request a mapping.
+type natPMPClient struct {
+	gateway net.IP
+	timeout time.Duration
+}
+
+const (
+	natPMPPort           = 5351
+	natPMPVersion        = 0
+	natPMPOpExternalAddr = 0
+	natPMPOpMapUDP       = 1
+	natPMPOpMapTCP       = 2
+)
+
+// discoverNATPMP finds the default gateway and confirms it speaks NAT-PMP by
+// requesting its external address.
+func discoverNATPMP(timeout time.Duration) (NATTraversal, error) {
+	gw, err := defaultGateway()
+	if err != nil {
+		return nil, err
+	}
+
+	c := &natPMPClient{gateway: gw, timeout: timeout}
+	if _, err := c.externalAddr(); err != nil {
+		return nil, fmt.Errorf("NAT-PMP gateway %s did not respond: %w", gw, err)
+	}
+
+	return c, nil
+}
+
+func (c *natPMPClient) externalAddr() (net.IP, error) {
+	req := []byte{natPMPVersion, natPMPOpExternalAddr}
+
+	resp, err := c.roundTrip(req, 12)
+	if err != nil {
+		return nil, err
+	}
+
+	return net.IPv4(resp[8], resp[9], resp[10], resp[11]), nil
+}
+
+func (c *natPMPClient) AddPortMapping(protocol string, internalPort int, _ string) (net.Addr, time.Duration, error) {
+	op := byte(natPMPOpMapUDP)
+	if protocol == "tcp" {
+		op = natPMPOpMapTCP
+	}
+
+	const requestedLifetime = 7200 // seconds, renewed well before expiry by the caller
+
+	req := make([]byte, 12)
+	req[0] = natPMPVersion
+	req[1] = op
+	binary.BigEndian.PutUint16(req[4:6], uint16(internalPort))
+	binary.BigEndian.PutUint16(req[6:8], uint16(internalPort))
+	binary.BigEndian.PutUint32(req[8:12], requestedLifetime)
+
+	resp, err := c.roundTrip(req, 16)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	resultCode := binary.BigEndian.Uint16(resp[2:4])
+	if resultCode != 0 {
+		return nil, 0, fmt.Errorf("NAT-PMP mapping request failed with result code %d", resultCode)
+	}
+
+	externalPort := binary.BigEndian.Uint16(resp[10:12])
+	lifetime := binary.BigEndian.Uint32(resp[12:16])
+
+	externalIP, err := c.externalAddr()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	switch protocol {
+	case "udp":
+		return &net.UDPAddr{IP: externalIP, Port: int(externalPort)}, time.Duration(lifetime) * time.Second, nil
+	case "tcp":
+		return &net.TCPAddr{IP: externalIP, Port: int(externalPort)}, time.Duration(lifetime) * time.Second, nil
+	default:
+		return nil, 0, fmt.Errorf("unsupported protocol for NAT-PMP: %s", protocol)
+	}
+}
+
+func (c *natPMPClient) RemovePortMapping(protocol string, internalPort int) error {
+	op := byte(natPMPOpMapUDP)
+	if protocol == "tcp" {
+		op = natPMPOpMapTCP
+	}
+
+	// A requested lifetime of 0 tells the gateway to destroy the mapping.
+	req := make([]byte, 12)
+	req[0] = natPMPVersion
+	req[1] = op
+	binary.BigEndian.PutUint16(req[4:6], uint16(internalPort))
+
+	_, err := c.roundTrip(req, 16)
+	return err
+}
+
+func (c *natPMPClient) roundTrip(req []byte, respLen int) ([]byte, error) {
+	conn, err := net.DialTimeout("udp4", net.JoinHostPort(c.gateway.String(), fmt.Sprint(natPMPPort)), c.timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = conn.Close() }()
+
+	if err := conn.SetDeadline(time.Now().Add(c.timeout)); err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write(req); err != nil {
+		return nil, err
+	}
+
+	resp := make([]byte, respLen)
+	n, err := conn.Read(resp)
+	if err != nil {
+		return nil, err
+	} else if n < respLen {
+		return nil, fmt.Errorf("short NAT-PMP response: got %d bytes, want %d", n, respLen)
+	}
+
+	return resp, nil
+}
+
+// defaultGateway guesses the first-hop gateway for the host's default route.
+// It dials a UDP "connection" to an outside address, reads back the local
+// IPv4 address the routing decision picked, and returns that address with
+// its last octet set to 1, which requires no elevated privileges and no
+// platform-specific syscalls. A gateway at any other address is not found.
+func defaultGateway() (net.IP, error) {
+	conn, err := net.Dial("udp4", "198.18.0.1:80")
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = conn.Close() }()
+
+	localAddr, ok := conn.LocalAddr().(*net.UDPAddr)
+	if !ok {
+		return nil, fmt.Errorf("failed to determine local address")
+	}
+
+	gw := localAddr.IP.To4()
+	if gw == nil {
+		return nil, fmt.Errorf("no IPv4 route to determine default gateway")
+	}
+	gw[3] = 1
+
+	return gw, nil
+}
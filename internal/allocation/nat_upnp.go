@@ -0,0 +1,243 @@
+package allocation
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// upnpClient is a minimal UPnP IGDv1/v2 client: enough SSDP discovery and
+// SOAP control-point calls to request a WANIPConnection/WANPPPConnection
+// port mapping. It does not attempt full device/service description
+// parsing; it scrapes the control URL out of the device XML, which is
+// sufficient for the IGD profiles in practice.
+type upnpClient struct {
+	controlURL  string
+	serviceType string
+	timeout     time.Duration
+}
+
+const ssdpSearchTarget = "urn:schemas-upnp-org:service:WANIPConnection:1"
+
+// discoverUPnP performs an SSDP M-SEARCH for an Internet Gateway Device and
+// resolves its WANIPConnection control URL.
+func discoverUPnP(timeout time.Duration) (NATTraversal, error) {
+	location, err := ssdpSearch(timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	controlURL, serviceType, err := fetchControlURL(location, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	return &upnpClient{controlURL: controlURL, serviceType: serviceType, timeout: timeout}, nil
+}
+
+func ssdpSearch(timeout time.Duration) (string, error) {
+	conn, err := net.ListenPacket("udp4", ":0")
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = conn.Close() }()
+
+	dst, err := net.ResolveUDPAddr("udp4", "239.255.255.250:1900")
+	if err != nil {
+		return "", err
+	}
+
+	req := "M-SEARCH * HTTP/1.1\r\n" +
+		"HOST: 239.255.255.250:1900\r\n" +
+		"MAN: \"ssdp:discover\"\r\n" +
+		"MX: 2\r\n" +
+		"ST: " + ssdpSearchTarget + "\r\n\r\n"
+
+	if _, err := conn.WriteTo([]byte(req), dst); err != nil {
+		return "", err
+	}
+
+	if err := conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return "", err
+	}
+
+	buf := make([]byte, 2048)
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		return "", fmt.Errorf("no SSDP reply from an IGD: %w", err)
+	}
+
+	loc := regexp.MustCompile(`(?i)LOCATION:\s*(\S+)`).FindStringSubmatch(string(buf[:n]))
+	if len(loc) < 2 {
+		return "", fmt.Errorf("SSDP reply did not contain a LOCATION header")
+	}
+
+	return strings.TrimSpace(loc[1]), nil
+}
+
+// fetchControlURL downloads the device description XML at location and
+// scrapes out the WANIPConnection (or WANPPPConnection, for IGDv2 devices
+// that only expose PPP) control URL.
+func fetchControlURL(location string, timeout time.Duration) (controlURL, serviceType string, err error) {
+	httpClient := &http.Client{Timeout: timeout}
+
+	resp, err := httpClient.Get(location)
+	if err != nil {
+		return "", "", err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", "", err
+	}
+
+	base, err := url.Parse(location)
+	if err != nil {
+		return "", "", fmt.Errorf("malformed device description URL: %w", err)
+	}
+
+	for _, st := range []string{"WANIPConnection:2", "WANIPConnection:1", "WANPPPConnection:1"} {
+		svcType := "urn:schemas-upnp-org:service:" + st
+		pattern := regexp.MustCompile(`(?s)<serviceType>` + regexp.QuoteMeta(svcType) + `</serviceType>.*?<controlURL>(.*?)</controlURL>`)
+		if m := pattern.FindSubmatch(body); m != nil {
+			rel, perr := url.Parse(string(m[1]))
+			if perr != nil {
+				return "", "", perr
+			}
+			return base.ResolveReference(rel).String(), svcType, nil
+		}
+	}
+
+	return "", "", fmt.Errorf("no WANIPConnection/WANPPPConnection service found in device description")
+}
+
+func (c *upnpClient) AddPortMapping(protocol string, internalPort int, description string) (net.Addr, time.Duration, error) {
+	localIP, err := localIPFor(c.controlURL)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	const requestedLifetime = 7200 // seconds
+
+	body := fmt.Sprintf(`<u:AddPortMapping xmlns:u="%s">
+<NewRemoteHost></NewRemoteHost>
+<NewExternalPort>%d</NewExternalPort>
+<NewProtocol>%s</NewProtocol>
+<NewInternalPort>%d</NewInternalPort>
+<NewInternalClient>%s</NewInternalClient>
+<NewEnabled>1</NewEnabled>
+<NewPortMappingDescription>%s</NewPortMappingDescription>
+<NewLeaseDuration>%d</NewLeaseDuration>
+</u:AddPortMapping>`, c.serviceType, internalPort, strings.ToUpper(protocol), internalPort, localIP, description, requestedLifetime)
+
+	if _, err := c.soapCall("AddPortMapping", body); err != nil {
+		return nil, 0, err
+	}
+
+	externalIP, err := c.externalIPAddr()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	switch protocol {
+	case "udp":
+		return &net.UDPAddr{IP: externalIP, Port: internalPort}, requestedLifetime * time.Second, nil
+	case "tcp":
+		return &net.TCPAddr{IP: externalIP, Port: internalPort}, requestedLifetime * time.Second, nil
+	default:
+		return nil, 0, fmt.Errorf("unsupported protocol for UPnP: %s", protocol)
+	}
+}
+
+func (c *upnpClient) RemovePortMapping(protocol string, internalPort int) error {
+	body := fmt.Sprintf(`<u:DeletePortMapping xmlns:u="%s">
+<NewRemoteHost></NewRemoteHost>
+<NewExternalPort>%d</NewExternalPort>
+<NewProtocol>%s</NewProtocol>
+</u:DeletePortMapping>`, c.serviceType, internalPort, strings.ToUpper(protocol))
+
+	_, err := c.soapCall("DeletePortMapping", body)
+	return err
+}
+
+func (c *upnpClient) externalIPAddr() (net.IP, error) {
+	body := fmt.Sprintf(`<u:GetExternalIPAddress xmlns:u="%s"></u:GetExternalIPAddress>`, c.serviceType)
+
+	resp, err := c.soapCall("GetExternalIPAddress", body)
+	if err != nil {
+		return nil, err
+	}
+
+	m := regexp.MustCompile(`<NewExternalIPAddress>(.*?)</NewExternalIPAddress>`).FindSubmatch(resp)
+	if m == nil {
+		return nil, fmt.Errorf("GetExternalIPAddress response missing NewExternalIPAddress")
+	}
+
+	ip := net.ParseIP(strings.TrimSpace(string(m[1])))
+	if ip == nil {
+		return nil, fmt.Errorf("gateway returned an invalid external IP: %q", m[1])
+	}
+
+	return ip, nil
+}
+
+func (c *upnpClient) soapCall(action, body string) ([]byte, error) {
+	envelope := `<?xml version="1.0"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">
+<s:Body>` + body + `</s:Body>
+</s:Envelope>`
+
+	req, err := http.NewRequest(http.MethodPost, c.controlURL, bytes.NewBufferString(envelope))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	req.Header.Set("SOAPAction", fmt.Sprintf(`"%s#%s"`, c.serviceType, action))
+
+	httpClient := &http.Client{Timeout: c.timeout}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("UPnP %s call failed with status %s: %s", action, resp.Status, respBody)
+	}
+
+	return respBody, nil
+}
+
+// localIPFor returns the local address used to reach the gateway hosting
+// controlURL, for the NewInternalClient field of AddPortMapping.
+func localIPFor(controlURL string) (string, error) {
+	u, err := url.Parse(controlURL)
+	if err != nil {
+		return "", err
+	}
+
+	conn, err := net.Dial("udp4", net.JoinHostPort(u.Hostname(), "80"))
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = conn.Close() }()
+
+	localAddr, ok := conn.LocalAddr().(*net.UDPAddr)
+	if !ok {
+		return "", fmt.Errorf("failed to determine local address")
+	}
+
+	return localAddr.IP.String(), nil
+}
@@ -0,0 +1,220 @@
+package allocation
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrQuotaAllocations is returned when a source IP already holds
+	// Quota.MaxAllocationsPerIP concurrent allocations, or the server as a
+	// whole already holds Quota.MaxTotalAllocations.
+	ErrQuotaAllocations = errors.New("allocation quota exceeded")
+
+	// ErrQuotaRate is returned when a source IP has used up its token
+	// bucket, which holds Quota.MaxAllocationsPerMinutePerIP tokens and
+	// refills continuously at that many per minute.
+	ErrQuotaRate = errors.New("allocation rate quota exceeded")
+
+	// ErrQuotaConnections is returned when an allocation already has
+	// Quota.MaxConnectionsPerAllocation pending or active peer data
+	// connections.
+	ErrQuotaConnections = errors.New("peer connection quota exceeded")
+)
+
+// Quota bounds the resources a single source IP, or the server as a whole,
+// may consume. A zero value leaves the corresponding dimension unlimited,
+// matching Manager's pre-existing unbounded behaviour.
+type Quota struct {
+	MaxAllocationsPerIP          int
+	MaxAllocationsPerMinutePerIP int
+	MaxTotalAllocations          int
+	MaxConnectionsPerAllocation  int
+}
+
+// Stats is a point-in-time snapshot of Manager's resource usage, meant to be
+// polled periodically and wired into a metrics exporter.
+type Stats struct {
+	TotalAllocations     int
+	AllocationsByIP      map[string]int
+	TotalPeerConnections int
+}
+
+// bucketIdleEvictionWindow bounds how long a per-IP rate-limit bucket is
+// kept around after that IP's last allocation goes away. Without this,
+// client churn across many source IPs on a public server would grow
+// quotaTracker.buckets forever.
+const bucketIdleEvictionWindow = 2 * time.Minute
+
+// quotaTracker enforces a Quota across concurrent CreateAllocation/Connect
+// calls. A nil *quotaTracker (no Quota configured) enforces nothing.
+type quotaTracker struct {
+	cfg   Quota
+	clock Clock
+
+	mu      sync.Mutex
+	perIP   map[string]int
+	buckets map[string]*tokenBucket
+	total   int
+}
+
+func newQuotaTracker(cfg Quota, clock Clock) *quotaTracker {
+	return &quotaTracker{
+		cfg:     cfg,
+		clock:   clock,
+		perIP:   make(map[string]int),
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// reserve checks ip against every configured limit and, if all pass,
+// accounts for one more allocation from ip. It must be paired with a later
+// release(ip) once that allocation goes away.
+func (q *quotaTracker) reserve(ip string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.cfg.MaxTotalAllocations > 0 && q.total >= q.cfg.MaxTotalAllocations {
+		return ErrQuotaAllocations
+	}
+	if q.cfg.MaxAllocationsPerIP > 0 && q.perIP[ip] >= q.cfg.MaxAllocationsPerIP {
+		return ErrQuotaAllocations
+	}
+	if q.cfg.MaxAllocationsPerMinutePerIP > 0 {
+		bucket, ok := q.buckets[ip]
+		if !ok {
+			bucket = newTokenBucket(q.cfg.MaxAllocationsPerMinutePerIP, q.clock)
+			q.buckets[ip] = bucket
+		}
+		if !bucket.take() {
+			return ErrQuotaRate
+		}
+	}
+
+	q.perIP[ip]++
+	q.total++
+
+	return nil
+}
+
+func (q *quotaTracker) release(ip string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.perIP[ip] > 0 {
+		q.perIP[ip]--
+		if q.perIP[ip] == 0 {
+			delete(q.perIP, ip)
+			q.scheduleBucketEvictionLocked(ip)
+		}
+	}
+	if q.total > 0 {
+		q.total--
+	}
+}
+
+// scheduleBucketEvictionLocked arranges for ip's rate-limit bucket to be
+// dropped bucketIdleEvictionWindow after this release if ip holds no
+// allocation at that moment, so churn across many source IPs doesn't grow
+// q.buckets forever. It's a no-op if ip has no bucket. Only that moment is
+// checked: an allocation ip created and released again in between does not
+// postpone the drop. Callers must hold q.mu.
+func (q *quotaTracker) scheduleBucketEvictionLocked(ip string) {
+	if _, ok := q.buckets[ip]; !ok {
+		return
+	}
+	q.clock.AfterFunc(bucketIdleEvictionWindow, func() {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		if _, stillHoldsAllocation := q.perIP[ip]; !stillHoldsAllocation {
+			delete(q.buckets, ip)
+		}
+	})
+}
+
+func (q *quotaTracker) snapshot() (total int, byIP map[string]int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	byIP = make(map[string]int, len(q.perIP))
+	for ip, n := range q.perIP {
+		byIP[ip] = n
+	}
+	return q.total, byIP
+}
+
+// tokenBucket is a simple per-minute token bucket: it holds up to `rate`
+// tokens, refilling continuously at `rate` tokens per minute.
+type tokenBucket struct {
+	rate       float64
+	tokens     float64
+	lastRefill time.Time
+	clock      Clock
+}
+
+func newTokenBucket(ratePerMinute int, clock Clock) *tokenBucket {
+	return &tokenBucket{
+		rate:       float64(ratePerMinute),
+		tokens:     float64(ratePerMinute),
+		lastRefill: clock.Now(),
+		clock:      clock,
+	}
+}
+
+func (b *tokenBucket) take() bool {
+	now := b.clock.Now()
+	elapsed := now.Sub(b.lastRefill).Minutes()
+	b.lastRefill = now
+
+	b.tokens += elapsed * b.rate
+	if b.tokens > b.rate {
+		b.tokens = b.rate
+	}
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// ipFromAddr extracts the IP portion of a UDP or TCP address, for grouping
+// allocations and quotas by source IP regardless of source port.
+func ipFromAddr(addr net.Addr) string {
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		return a.IP.String()
+	case *net.TCPAddr:
+		return a.IP.String()
+	default:
+		host, _, err := net.SplitHostPort(addr.String())
+		if err != nil {
+			return addr.String()
+		}
+		return host
+	}
+}
+
+// Stats returns a snapshot of Manager's current resource usage.
+func (m *Manager) Stats() Stats {
+	m.lock.RLock()
+	totalConns := len(m.waitingconns) + len(m.runningconns)
+	m.lock.RUnlock()
+
+	stats := Stats{TotalPeerConnections: totalConns}
+	if m.quota != nil {
+		stats.TotalAllocations, stats.AllocationsByIP = m.quota.snapshot()
+		return stats
+	}
+
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	stats.TotalAllocations = len(m.allocations)
+	stats.AllocationsByIP = make(map[string]int)
+	for _, a := range m.allocations {
+		stats.AllocationsByIP[ipFromAddr(a.fiveTuple.SrcAddr)]++
+	}
+	return stats
+}
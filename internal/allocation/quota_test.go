@@ -0,0 +1,95 @@
+package allocation_test
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pion/logging"
+
+	"github.com/schivei/turn/internal/allocation"
+	"github.com/schivei/turn/internal/allocation/simnet"
+)
+
+// TestMaxConnectionsPerAllocationQuota drives many concurrent Connect calls
+// against a single allocation whose Quota.MaxConnectionsPerAllocation is
+// lower than the attempt count, and asserts that exactly the configured
+// number succeed and the rest fail with ErrQuotaConnections. A regression
+// here would mean either the quota isn't enforced at all, or reserveCID's
+// check-and-register isn't atomic and lets concurrent callers overrun it.
+func TestMaxConnectionsPerAllocationQuota(t *testing.T) {
+	log := logging.NewDefaultLoggerFactory().NewLogger("test")
+	sw := simnet.NewSwitch(net.IPv4(10, 0, 0, 1))
+	clock := simnet.NewManualClock(time.Unix(0, 0))
+
+	const limit = 2
+
+	m, err := allocation.NewManager(allocation.ManagerConfig{
+		LeveledLogger:      log,
+		AllocatePacketConn: sw.AllocatePacketConn,
+		AllocateConn:       sw.AllocateConn,
+		NATTraversal:       allocation.DisabledNATTraversal,
+		Clock:              clock,
+		Quota:              allocation.Quota{MaxConnectionsPerAllocation: limit},
+	})
+	if err != nil {
+		t.Fatalf("failed to create manager: %v", err)
+	}
+	defer func() {
+		if err := m.Close(); err != nil {
+			t.Fatalf("failed to close manager: %v", err)
+		}
+	}()
+
+	turnSocket, _, err := sw.AllocatePacketConn("udp4", 0)
+	if err != nil {
+		t.Fatalf("failed to allocate turn socket: %v", err)
+	}
+	defer turnSocket.Close()
+
+	fiveTuple := &allocation.FiveTuple{
+		Protocol: allocation.UDP,
+		SrcAddr:  &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 5000},
+		DstAddr:  &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 3478},
+	}
+
+	a, err := m.CreateAllocation(fiveTuple, turnSocket, 0, time.Minute)
+	if err != nil {
+		t.Fatalf("failed to create allocation: %v", err)
+	}
+
+	const attempts = 8
+	results := make([]error, attempts)
+
+	var wg sync.WaitGroup
+	wg.Add(attempts)
+	for i := 0; i < attempts; i++ {
+		go func(i int) {
+			defer wg.Done()
+			_, results[i] = m.Connect(a, fmt.Sprintf("10.0.0.3:%d", 9000+i))
+		}(i)
+	}
+	wg.Wait()
+
+	var ok, quotaErr int
+	for _, err := range results {
+		switch {
+		case err == nil:
+			ok++
+		case errors.Is(err, allocation.ErrQuotaConnections):
+			quotaErr++
+		default:
+			t.Fatalf("unexpected Connect error: %v", err)
+		}
+	}
+
+	if ok != limit {
+		t.Fatalf("expected exactly %d successful Connect calls, got %d", limit, ok)
+	}
+	if quotaErr != attempts-limit {
+		t.Fatalf("expected %d calls to fail with ErrQuotaConnections, got %d", attempts-limit, quotaErr)
+	}
+}
@@ -0,0 +1,102 @@
+// Package simnet provides an in-memory network and a manually-advanced
+// clock for exercising allocation.Manager deterministically, without real
+// sockets or wall-clock sleeps.
+package simnet
+
+import (
+	"sync"
+	"time"
+
+	"github.com/schivei/turn/internal/allocation"
+)
+
+// ManualClock is an allocation.Clock that only moves when Advance is
+// called, so timing-sensitive behaviour (allocation lifetimes, the
+// 30-second peer connection timeout, NAT mapping refresh) can be driven
+// step by step in a test.
+type ManualClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*manualTimer
+}
+
+// NewManualClock returns a ManualClock starting at start.
+func NewManualClock(start time.Time) *ManualClock {
+	return &ManualClock{now: start}
+}
+
+// Now implements allocation.Clock.
+func (c *ManualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// After implements allocation.Clock.
+func (c *ManualClock) After(d time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	c.AfterFunc(d, func() { ch <- c.Now() })
+	return ch
+}
+
+// AfterFunc implements allocation.Clock.
+func (c *ManualClock) AfterFunc(d time.Duration, f func()) allocation.Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t := &manualTimer{fire: c.now.Add(d), f: f}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+// Advance moves the clock forward by d and synchronously runs every timer
+// whose deadline now falls at or before the new time, in the order they
+// were scheduled.
+func (c *ManualClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	now := c.now
+
+	due := make([]*manualTimer, 0)
+	live := c.timers[:0]
+	for _, t := range c.timers {
+		if t.isStopped() {
+			continue
+		}
+		if !t.fire.After(now) {
+			due = append(due, t)
+		} else {
+			live = append(live, t)
+		}
+	}
+	c.timers = live
+	c.mu.Unlock()
+
+	for _, t := range due {
+		if !t.isStopped() {
+			t.f()
+		}
+	}
+}
+
+type manualTimer struct {
+	mu      sync.Mutex
+	fire    time.Time
+	f       func()
+	stopped bool
+}
+
+func (t *manualTimer) isStopped() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.stopped
+}
+
+// Stop implements allocation.Timer.
+func (t *manualTimer) Stop() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	already := t.stopped
+	t.stopped = true
+	return !already
+}
@@ -0,0 +1,209 @@
+package simnet
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// Switch is an in-memory virtual network: a single IP address space that
+// PacketConns and Listeners created through it can address each other on,
+// without touching the host network stack.
+type Switch struct {
+	mu       sync.Mutex
+	host     net.IP
+	udp      map[int]*PacketConn
+	tcp      map[int]*Listener
+	nextPort int
+}
+
+// NewSwitch returns a Switch whose sockets all share host as their address.
+func NewSwitch(host net.IP) *Switch {
+	return &Switch{
+		host:     host,
+		udp:      make(map[int]*PacketConn),
+		tcp:      make(map[int]*Listener),
+		nextPort: 1024,
+	}
+}
+
+func (s *Switch) reservePortLocked(taken func(int) bool) int {
+	for {
+		s.nextPort++
+		if !taken(s.nextPort) {
+			return s.nextPort
+		}
+	}
+}
+
+// AllocatePacketConn satisfies allocation.ManagerConfig.AllocatePacketConn.
+func (s *Switch) AllocatePacketConn(_ string, requestedPort int) (net.PacketConn, net.Addr, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	port := requestedPort
+	if port == 0 {
+		port = s.reservePortLocked(func(p int) bool { _, ok := s.udp[p]; return ok })
+	} else if _, taken := s.udp[port]; taken {
+		return nil, nil, fmt.Errorf("simnet: udp port %d already in use", port)
+	}
+
+	addr := &net.UDPAddr{IP: s.host, Port: port}
+	conn := &PacketConn{
+		sw:      s,
+		addr:    addr,
+		inbound: make(chan udpPacket, 64),
+		closed:  make(chan struct{}),
+	}
+	s.udp[port] = conn
+
+	return conn, addr, nil
+}
+
+// AllocateConn satisfies allocation.ManagerConfig.AllocateConn.
+func (s *Switch) AllocateConn(_ string, requestedPort int) (net.Listener, net.Addr, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	port := requestedPort
+	if port == 0 {
+		port = s.reservePortLocked(func(p int) bool { _, ok := s.tcp[p]; return ok })
+	} else if _, taken := s.tcp[port]; taken {
+		return nil, nil, fmt.Errorf("simnet: tcp port %d already in use", port)
+	}
+
+	addr := &net.TCPAddr{IP: s.host, Port: port}
+	l := &Listener{
+		sw:     s,
+		addr:   addr,
+		accept: make(chan net.Conn, 16),
+		closed: make(chan struct{}),
+	}
+	s.tcp[port] = l
+
+	return l, addr, nil
+}
+
+// Dial opens a virtual TCP connection to a Listener previously created via
+// AllocateConn, delivering the peer half to that Listener's Accept.
+func (s *Switch) Dial(raddr *net.TCPAddr) (net.Conn, error) {
+	s.mu.Lock()
+	l, ok := s.tcp[raddr.Port]
+	s.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("simnet: no listener on tcp port %d", raddr.Port)
+	}
+
+	local, remote := net.Pipe()
+	select {
+	case l.accept <- remote:
+		return local, nil
+	case <-l.closed:
+		return nil, fmt.Errorf("simnet: listener on tcp port %d is closed", raddr.Port)
+	}
+}
+
+func (s *Switch) removeUDP(port int) {
+	s.mu.Lock()
+	delete(s.udp, port)
+	s.mu.Unlock()
+}
+
+func (s *Switch) removeTCP(port int) {
+	s.mu.Lock()
+	delete(s.tcp, port)
+	s.mu.Unlock()
+}
+
+func (s *Switch) deliverUDP(dst *net.UDPAddr, pkt udpPacket) error {
+	s.mu.Lock()
+	conn, ok := s.udp[dst.Port]
+	s.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("simnet: no udp socket on port %d", dst.Port)
+	}
+
+	select {
+	case conn.inbound <- pkt:
+		return nil
+	default:
+		return fmt.Errorf("simnet: udp port %d inbound buffer full", dst.Port)
+	}
+}
+
+type udpPacket struct {
+	data []byte
+	from net.Addr
+}
+
+// PacketConn is a net.PacketConn backed by a Switch.
+type PacketConn struct {
+	sw        *Switch
+	addr      *net.UDPAddr
+	inbound   chan udpPacket
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (c *PacketConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	select {
+	case pkt := <-c.inbound:
+		return copy(p, pkt.data), pkt.from, nil
+	case <-c.closed:
+		return 0, nil, net.ErrClosed
+	}
+}
+
+func (c *PacketConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	dst, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return 0, fmt.Errorf("simnet: WriteTo requires a *net.UDPAddr, got %T", addr)
+	}
+	if err := c.sw.deliverUDP(dst, udpPacket{data: append([]byte(nil), p...), from: c.addr}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *PacketConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		c.sw.removeUDP(c.addr.Port)
+	})
+	return nil
+}
+
+func (c *PacketConn) LocalAddr() net.Addr { return c.addr }
+
+func (c *PacketConn) SetDeadline(time.Time) error      { return nil }
+func (c *PacketConn) SetReadDeadline(time.Time) error  { return nil }
+func (c *PacketConn) SetWriteDeadline(time.Time) error { return nil }
+
+// Listener is a net.Listener backed by a Switch.
+type Listener struct {
+	sw        *Switch
+	addr      *net.TCPAddr
+	accept    chan net.Conn
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.accept:
+		return c, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *Listener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.closed)
+		l.sw.removeTCP(l.addr.Port)
+	})
+	return nil
+}
+
+func (l *Listener) Addr() net.Addr { return l.addr }
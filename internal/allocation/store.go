@@ -0,0 +1,160 @@
+package allocation
+
+import (
+	"sync"
+	"time"
+)
+
+// AllocationRecord is the durable representation of an Allocation, enough to
+// recreate its bookkeeping and re-bind its relay port across a restart.
+type AllocationRecord struct {
+	Fingerprint   string
+	Protocol      string // "udp" or "tcp"; re-bound on restore via AllocatePacketConn("udp4") or AllocateConn("tcp4")
+	RequestedPort int
+	Deadline      time.Time
+}
+
+// ReservationRecord is the durable representation of a CreateReservation call.
+type ReservationRecord struct {
+	Token string
+	Port  int
+}
+
+// StoreSnapshot is everything Store.LoadAll hands back to Manager on startup.
+type StoreSnapshot struct {
+	Allocations  []AllocationRecord
+	Reservations []ReservationRecord
+}
+
+// Store persists allocation and reservation state so it survives a process
+// restart. Implementations must be safe for concurrent use; Manager calls
+// Save/Delete off the hot path (see asyncStore), so they may block.
+type Store interface {
+	SaveAllocation(rec AllocationRecord) error
+	DeleteAllocation(fingerprint string) error
+	SaveReservation(rec ReservationRecord) error
+	DeleteReservation(token string) error
+
+	// LoadAll returns every record written before the most recent Close,
+	// for Manager to rebuild its in-memory state from on startup.
+	LoadAll() (StoreSnapshot, error)
+
+	Close() error
+}
+
+// memoryStore is the default Store: it satisfies the interface but keeps no
+// state across restarts, matching the manager's pre-existing behaviour.
+type memoryStore struct{}
+
+// NewMemoryStore returns a Store that discards everything, for callers that
+// don't need allocations to survive a restart.
+func NewMemoryStore() Store { return memoryStore{} }
+
+func (memoryStore) SaveAllocation(AllocationRecord) error   { return nil }
+func (memoryStore) DeleteAllocation(string) error           { return nil }
+func (memoryStore) SaveReservation(ReservationRecord) error { return nil }
+func (memoryStore) DeleteReservation(string) error          { return nil }
+func (memoryStore) LoadAll() (StoreSnapshot, error)         { return StoreSnapshot{}, nil }
+func (memoryStore) Close() error                            { return nil }
+
+// storeOp is a single queued write, applied to the backing Store off m.lock.
+type storeOp struct {
+	apply func(Store) error
+}
+
+// asyncStore wraps a Store so that writes are queued and applied by a single
+// background goroutine, keeping Store I/O off the caller's hot path (and
+// off m.lock) while still applying writes in submission order.
+type asyncStore struct {
+	backing Store
+	log     logLeveler
+
+	ops  chan storeOp
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+func newAsyncStore(backing Store, log logLeveler) *asyncStore {
+	s := &asyncStore{
+		backing: backing,
+		log:     log,
+		ops:     make(chan storeOp, 256),
+		done:    make(chan struct{}),
+	}
+
+	s.wg.Add(1)
+	go s.run()
+
+	return s
+}
+
+func (s *asyncStore) run() {
+	defer s.wg.Done()
+	for {
+		select {
+		case op := <-s.ops:
+			if err := op.apply(s.backing); err != nil {
+				s.log.Errorf("allocation store write failed: %v", err)
+			}
+		case <-s.done:
+			// Drain whatever was already enqueued before close() was
+			// called; the select above picks non-deterministically when
+			// both s.ops and s.done are ready, so a write submitted right
+			// before Close() must still be applied here.
+			s.drain()
+			return
+		}
+	}
+}
+
+// drain applies every op already sitting in s.ops without blocking, for
+// run() to call once s.done fires so a graceful shutdown doesn't lose
+// writes that were enqueued just before it.
+func (s *asyncStore) drain() {
+	for {
+		select {
+		case op := <-s.ops:
+			if err := op.apply(s.backing); err != nil {
+				s.log.Errorf("allocation store write failed: %v", err)
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (s *asyncStore) enqueue(apply func(Store) error) {
+	select {
+	case s.ops <- storeOp{apply: apply}:
+	case <-s.done:
+	}
+}
+
+func (s *asyncStore) saveAllocation(rec AllocationRecord) {
+	s.enqueue(func(store Store) error { return store.SaveAllocation(rec) })
+}
+
+func (s *asyncStore) deleteAllocation(fingerprint string) {
+	s.enqueue(func(store Store) error { return store.DeleteAllocation(fingerprint) })
+}
+
+func (s *asyncStore) saveReservation(rec ReservationRecord) {
+	s.enqueue(func(store Store) error { return store.SaveReservation(rec) })
+}
+
+func (s *asyncStore) deleteReservation(token string) {
+	s.enqueue(func(store Store) error { return store.DeleteReservation(token) })
+}
+
+// loadAll reads synchronously; it only runs once, from NewManager, before
+// the background writer has anything queued.
+func (s *asyncStore) loadAll() (StoreSnapshot, error) {
+	return s.backing.LoadAll()
+}
+
+// close drains the queue and closes the backing Store.
+func (s *asyncStore) close() error {
+	close(s.done)
+	s.wg.Wait()
+	return s.backing.Close()
+}
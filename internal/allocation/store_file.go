@@ -0,0 +1,187 @@
+package allocation
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// FileStore is a Store backed by a single append-only JSON-lines file. Each
+// call to Save/Delete appends one entry carrying an Op, so LoadAll can
+// replay the file and let the last entry for a given key win; it then
+// rewrites the file down to just the live records (see compactLocked) so it
+// doesn't grow unbounded across restarts.
+type FileStore struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+}
+
+type fileStoreOp string
+
+const (
+	opSaveAllocation    fileStoreOp = "save_allocation"
+	opDeleteAllocation  fileStoreOp = "delete_allocation"
+	opSaveReservation   fileStoreOp = "save_reservation"
+	opDeleteReservation fileStoreOp = "delete_reservation"
+)
+
+type fileStoreEntry struct {
+	Op          fileStoreOp
+	Allocation  *AllocationRecord  `json:",omitempty"`
+	Reservation *ReservationRecord `json:",omitempty"`
+	Key         string             `json:",omitempty"` // fingerprint or token, for delete ops
+}
+
+// NewFileStore opens (creating if necessary) a FileStore at path.
+func NewFileStore(path string) (*FileStore, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open allocation store %s: %w", path, err)
+	}
+
+	return &FileStore{path: path, file: f}, nil
+}
+
+func (s *FileStore) append(entry fileStoreEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	_, err = s.file.Write(append(line, '\n'))
+	return err
+}
+
+// SaveAllocation implements Store.
+func (s *FileStore) SaveAllocation(rec AllocationRecord) error {
+	return s.append(fileStoreEntry{Op: opSaveAllocation, Allocation: &rec})
+}
+
+// DeleteAllocation implements Store.
+func (s *FileStore) DeleteAllocation(fingerprint string) error {
+	return s.append(fileStoreEntry{Op: opDeleteAllocation, Key: fingerprint})
+}
+
+// SaveReservation implements Store.
+func (s *FileStore) SaveReservation(rec ReservationRecord) error {
+	return s.append(fileStoreEntry{Op: opSaveReservation, Reservation: &rec})
+}
+
+// DeleteReservation implements Store.
+func (s *FileStore) DeleteReservation(token string) error {
+	return s.append(fileStoreEntry{Op: opDeleteReservation, Key: token})
+}
+
+// LoadAll replays the log and compacts it back to disk, so the file doesn't
+// grow unbounded across many restarts.
+func (s *FileStore) LoadAll() (StoreSnapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.file.Seek(0, 0); err != nil {
+		return StoreSnapshot{}, err
+	}
+
+	allocations := make(map[string]AllocationRecord)
+	reservations := make(map[string]ReservationRecord)
+
+	scanner := bufio.NewScanner(s.file)
+	for scanner.Scan() {
+		var entry fileStoreEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return StoreSnapshot{}, fmt.Errorf("corrupt allocation store entry: %w", err)
+		}
+
+		switch entry.Op {
+		case opSaveAllocation:
+			allocations[entry.Allocation.Fingerprint] = *entry.Allocation
+		case opDeleteAllocation:
+			delete(allocations, entry.Key)
+		case opSaveReservation:
+			reservations[entry.Reservation.Token] = *entry.Reservation
+		case opDeleteReservation:
+			delete(reservations, entry.Key)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return StoreSnapshot{}, err
+	}
+
+	snapshot := StoreSnapshot{
+		Allocations:  make([]AllocationRecord, 0, len(allocations)),
+		Reservations: make([]ReservationRecord, 0, len(reservations)),
+	}
+	for _, rec := range allocations {
+		snapshot.Allocations = append(snapshot.Allocations, rec)
+	}
+	for _, rec := range reservations {
+		snapshot.Reservations = append(snapshot.Reservations, rec)
+	}
+
+	if err := s.compactLocked(snapshot); err != nil {
+		return StoreSnapshot{}, err
+	}
+
+	return snapshot, nil
+}
+
+// compactLocked rewrites the log file to contain exactly one save entry per
+// live record, dropping the history of deletes/overwrites. Caller must hold s.mu.
+func (s *FileStore) compactLocked(snapshot StoreSnapshot) error {
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), "allocation-store-compact-*")
+	if err != nil {
+		return err
+	}
+
+	for _, rec := range snapshot.Allocations {
+		entry := fileStoreEntry{Op: opSaveAllocation, Allocation: &rec}
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		if _, err := tmp.Write(append(line, '\n')); err != nil {
+			return err
+		}
+	}
+	for _, rec := range snapshot.Reservations {
+		entry := fileStoreEntry{Op: opSaveReservation, Reservation: &rec}
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		if _, err := tmp.Write(append(line, '\n')); err != nil {
+			return err
+		}
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := s.file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(s.path, os.O_RDWR|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	s.file = f
+
+	return nil
+}
+
+// Close implements Store.
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}